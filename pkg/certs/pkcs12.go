@@ -0,0 +1,155 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package certs provides helpers for loading client/server identities
+// from the certificate container formats commonly used by OPC-UA
+// tooling, so that callers don't have to hand-roll PEM conversion
+// before handing a key/cert pair to uapolicy.Asymmetric.
+package certs
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/pkcs12"
+
+	"github.com/gopcua/opcua/pkg/crypto/file"
+	"github.com/gopcua/opcua/uapolicy"
+)
+
+// Error is a typed error returned by LoadPKCS12 so that callers can
+// distinguish why loading a bundle failed.
+type Error struct {
+	Reason string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("certs: %s: %v", e.Reason, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Error reasons returned by LoadPKCS12.
+const (
+	ReasonInvalidPassword = "invalid password"
+	ReasonUnsupportedKey  = "unsupported key type"
+	ReasonPolicyMismatch  = "key not compatible with security policy"
+)
+
+// minRSAKeyBits gives the minimum RSA modulus size, in bits, that Part 7
+// of the OPC-UA spec permits for a given SecurityPolicy. Policies not
+// listed here (including SecurityPolicyNone) impose no minimum.
+var minRSAKeyBits = map[string]int{
+	uapolicy.SecurityPolicyBasic256Sha256:      2048,
+	uapolicy.SecurityPolicyAes128Sha256RsaOaep: 2048,
+	uapolicy.SecurityPolicyAes256Sha256RsaPss:  2048,
+}
+
+// LoadPKCS12 decodes a password-protected PKCS#12 (.p12/.pfx) bundle of
+// the kind shipped by most Windows/OPC-UA tooling (UAExpert, Kepware)
+// and returns the RSA private key, the leaf certificate, and any CA
+// chain bundled alongside it. The returned values plug directly into
+// uapolicy.Asymmetric and SecureChannel endpoint configuration.
+//
+// securityPolicyURI is the SecurityPolicy (e.g. uapolicy.SecurityPolicyBasic256Sha256)
+// the identity will be used with; LoadPKCS12 rejects keys that are too
+// weak for that policy. Pass uapolicy.SecurityPolicyNone to skip the check.
+func LoadPKCS12(path, password, securityPolicyURI string) (*rsa.PrivateKey, *x509.Certificate, []*x509.Certificate, error) {
+	if path == "" {
+		return nil, nil, nil, errors.New("certs: path is empty")
+	}
+
+	der, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("certs: %w", err)
+	}
+
+	key, leaf, err := pkcs12.Decode(der, password)
+	if err != nil {
+		return nil, nil, nil, &Error{Reason: ReasonInvalidPassword, Err: err}
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, nil, &Error{Reason: ReasonUnsupportedKey, Err: fmt.Errorf("got %T, want *rsa.PrivateKey", key)}
+	}
+
+	chain, err := caChain(der, password, leaf)
+	if err != nil {
+		return nil, nil, nil, &Error{Reason: ReasonInvalidPassword, Err: err}
+	}
+
+	if min, ok := minRSAKeyBits[securityPolicyURI]; ok && rsaKey.N.BitLen() < min {
+		return nil, nil, nil, &Error{
+			Reason: ReasonPolicyMismatch,
+			Err:    fmt.Errorf("key is %d bits, %s requires at least %d", rsaKey.N.BitLen(), securityPolicyURI, min),
+		}
+	}
+
+	return rsaKey, leaf, chain, nil
+}
+
+// Identity bundles the client/server identity material extracted from a
+// PKCS#12 bundle: a SignerDecrypter ready to pass to
+// uapolicy.AsymmetricIdentity, the leaf certificate, and any CA chain.
+type Identity struct {
+	Signer uapolicy.SignerDecrypter
+	Leaf   *x509.Certificate
+	Chain  []*x509.Certificate
+}
+
+// LoadPKCS12Identity is LoadPKCS12 plus the glue every caller otherwise
+// has to write by hand: it wraps the extracted RSA key in
+// pkg/crypto/file.Signer so the result plugs straight into
+// uapolicy.AsymmetricIdentity as local.
+//
+// This repository does not include client/SecureChannel configuration
+// code to wire an Identity into directly; LoadPKCS12Identity only
+// removes the uapolicy.SignerDecrypter boilerplate, which is as far as
+// the wiring can go without that code to plug into.
+func LoadPKCS12Identity(path, password, securityPolicyURI string) (*Identity, error) {
+	key, leaf, chain, err := LoadPKCS12(path, password, securityPolicyURI)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Signer: file.New(key),
+		Leaf:   leaf,
+		Chain:  chain,
+	}, nil
+}
+
+// caChain returns the certificates bundled alongside leaf in the PKCS#12
+// container, excluding leaf itself. golang.org/x/crypto/pkcs12 only
+// exposes the full bag of PEM blocks via ToPEM, so the chain is
+// recovered by parsing those blocks and filtering out the leaf.
+func caChain(der []byte, password string, leaf *x509.Certificate) ([]*x509.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(der, password)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []*x509.Certificate
+	for _, block := range blocks {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if cert.Equal(leaf) {
+			continue
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package certs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/pkcs12"
+
+	"github.com/gopcua/opcua/uapolicy"
+)
+
+// testdata/*.p12 are generated with openssl (see the commands recorded
+// alongside this test) using -legacy RC2/3DES encryption, since
+// golang.org/x/crypto/pkcs12 only decodes that older PKCS#12 format:
+//
+//	openssl req -x509 -newkey rsa:2048 -keyout ca-key.pem -out ca-cert.pem \
+//	  -days 3650 -nodes -subj "/CN=Test CA"
+//	openssl req -newkey rsa:2048 -keyout leaf-key.pem -out leaf.csr -nodes -subj "/CN=Test Leaf"
+//	openssl x509 -req -in leaf.csr -CA ca-cert.pem -CAkey ca-key.pem -CAcreateserial \
+//	  -out leaf-cert.pem -days 3650
+//	openssl pkcs12 -export -legacy -inkey leaf-key.pem -in leaf-cert.pem \
+//	  -out valid.p12 -passout pass:testpass
+//	openssl pkcs12 -export -legacy -inkey leaf-key.pem -in leaf-cert.pem \
+//	  -certfile ca-cert.pem -out valid-with-chain.p12 -passout pass:testpass
+//
+// weak-key.p12 and ec-key.p12 are built the same way from a 1024-bit RSA
+// key and a P-256 EC key respectively, each self-signed by the same CA.
+const testdataPassword = "testpass"
+
+func TestLoadPKCS12Success(t *testing.T) {
+	key, leaf, chain, err := LoadPKCS12("testdata/valid.p12", testdataPassword, uapolicy.SecurityPolicyNone)
+	if err != nil {
+		t.Fatalf("LoadPKCS12: %v", err)
+	}
+	if key == nil {
+		t.Fatal("key is nil")
+	}
+	if leaf == nil {
+		t.Fatal("leaf certificate is nil")
+	}
+	if leaf.Subject.CommonName != "Test Leaf" {
+		t.Errorf("leaf CommonName = %q, want %q", leaf.Subject.CommonName, "Test Leaf")
+	}
+	// valid.p12 has no bundled CA certificate: golang.org/x/crypto/pkcs12's
+	// Decode only accepts a bundle with exactly a key bag and a cert bag,
+	// so LoadPKCS12 can only succeed on a bundle without a chain.
+	if len(chain) != 0 {
+		t.Errorf("chain = %d certs, want 0", len(chain))
+	}
+}
+
+func TestLoadPKCS12EmptyPath(t *testing.T) {
+	if _, _, _, err := LoadPKCS12("", testdataPassword, uapolicy.SecurityPolicyNone); err == nil {
+		t.Fatal("expected an error for an empty path")
+	}
+}
+
+func TestLoadPKCS12InvalidPassword(t *testing.T) {
+	_, _, _, err := LoadPKCS12("testdata/valid.p12", "wrong password", uapolicy.SecurityPolicyNone)
+	var certErr *Error
+	if !errors.As(err, &certErr) || certErr.Reason != ReasonInvalidPassword {
+		t.Fatalf("err = %v, want *Error{Reason: %q}", err, ReasonInvalidPassword)
+	}
+}
+
+func TestLoadPKCS12UnsupportedKeyType(t *testing.T) {
+	_, _, _, err := LoadPKCS12("testdata/ec-key.p12", testdataPassword, uapolicy.SecurityPolicyNone)
+	var certErr *Error
+	if !errors.As(err, &certErr) || certErr.Reason != ReasonUnsupportedKey {
+		t.Fatalf("err = %v, want *Error{Reason: %q}", err, ReasonUnsupportedKey)
+	}
+}
+
+func TestLoadPKCS12PolicyMismatch(t *testing.T) {
+	_, _, _, err := LoadPKCS12("testdata/weak-key.p12", testdataPassword, uapolicy.SecurityPolicyBasic256Sha256)
+	var certErr *Error
+	if !errors.As(err, &certErr) || certErr.Reason != ReasonPolicyMismatch {
+		t.Fatalf("err = %v, want *Error{Reason: %q}", err, ReasonPolicyMismatch)
+	}
+}
+
+func TestLoadPKCS12PolicyMismatchIgnoredForNone(t *testing.T) {
+	// The same under-sized key is accepted when securityPolicyURI is
+	// SecurityPolicyNone, which imposes no minimum.
+	if _, _, _, err := LoadPKCS12("testdata/weak-key.p12", testdataPassword, uapolicy.SecurityPolicyNone); err != nil {
+		t.Fatalf("LoadPKCS12 with SecurityPolicyNone: %v", err)
+	}
+}
+
+func TestCaChainFiltersLeaf(t *testing.T) {
+	der, err := os.ReadFile("testdata/valid-with-chain.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// valid-with-chain.p12 carries three safe bags (key, leaf cert, CA
+	// cert), which pkcs12.Decode rejects outright, so LoadPKCS12 itself
+	// can never reach this bundle. caChain only needs ToPEM, which has
+	// no such restriction, so it's exercised directly here. The leaf
+	// cert comes from valid.p12, the same leaf key/cert pair without
+	// the bundled CA.
+	_, leaf, err := pkcs12.Decode(mustReadFile(t, "testdata/valid.p12"), testdataPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := caChain(der, testdataPassword, leaf)
+	if err != nil {
+		t.Fatalf("caChain: %v", err)
+	}
+	if len(chain) != 1 {
+		t.Fatalf("chain = %d certs, want 1", len(chain))
+	}
+	if chain[0].Subject.CommonName != "Test CA" {
+		t.Errorf("chain[0].Subject.CommonName = %q, want %q", chain[0].Subject.CommonName, "Test CA")
+	}
+	for _, cert := range chain {
+		if cert.Equal(leaf) {
+			t.Fatal("chain should not include the leaf certificate")
+		}
+	}
+}
+
+func TestLoadPKCS12IdentitySuccess(t *testing.T) {
+	identity, err := LoadPKCS12Identity("testdata/valid.p12", testdataPassword, uapolicy.SecurityPolicyNone)
+	if err != nil {
+		t.Fatalf("LoadPKCS12Identity: %v", err)
+	}
+	if identity.Signer == nil {
+		t.Fatal("Signer is nil")
+	}
+	if identity.Leaf == nil {
+		t.Fatal("Leaf is nil")
+	}
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
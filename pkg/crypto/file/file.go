@@ -0,0 +1,48 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package file adapts a PEM-loaded in-memory RSA private key to
+// uapolicy.SignerDecrypter, preserving today's behavior of keeping the
+// key in process memory. It exists alongside pkg/crypto/pkcs11 as the
+// reference "key stays in memory" implementation of the interface.
+package file
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"io"
+)
+
+// Signer wraps an in-memory *rsa.PrivateKey so it satisfies
+// uapolicy.SignerDecrypter.
+type Signer struct {
+	key *rsa.PrivateKey
+}
+
+// New wraps key as a uapolicy.SignerDecrypter.
+func New(key *rsa.PrivateKey) *Signer {
+	return &Signer{key: key}
+}
+
+// Public returns the public half of the wrapped key.
+func (s *Signer) Public() crypto.PublicKey {
+	return &s.key.PublicKey
+}
+
+// Sign signs digest with the wrapped key, as crypto.Signer.
+func (s *Signer) Sign(rnd io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rnd, digest, opts)
+}
+
+// Decrypt decrypts ciphertext with the wrapped key, as crypto.Decrypter.
+func (s *Signer) Decrypt(rnd io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return s.key.Decrypt(rnd, ciphertext, opts)
+}
+
+// RSAPrivateKey returns the wrapped key, letting uapolicy bridge this
+// adapter into security policies whose internals still require a
+// concrete *rsa.PrivateKey.
+func (s *Signer) RSAPrivateKey() *rsa.PrivateKey {
+	return s.key
+}
@@ -0,0 +1,131 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package pkcs11 adapts an RSA key held in a PKCS#11 token (an HSM or
+// a device such as a YubiHSM) to uapolicy.SignerDecrypter, so the
+// private key never has to leave the token. It is the reference
+// "key stays in hardware" implementation of the interface, alongside
+// the in-memory pkg/crypto/file adapter.
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"fmt"
+	"io"
+
+	"github.com/miekg/pkcs11"
+)
+
+// Signer wraps a PKCS#11 session and an RSA key handle within it as a
+// crypto.Signer/crypto.Decrypter pair.
+type Signer struct {
+	Ctx     *pkcs11.Ctx
+	Session pkcs11.SessionHandle
+	// Handle identifies the private key object within Session that
+	// Sign and Decrypt operate on.
+	Handle pkcs11.ObjectHandle
+	// Pub is the public half of Handle, used to satisfy crypto.Signer's
+	// Public method and to size RSA-OAEP/PKCS1v15 operations.
+	Pub *rsa.PublicKey
+}
+
+// New wraps an RSA key already loaded into a PKCS#11 session.
+func New(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, handle pkcs11.ObjectHandle, pub *rsa.PublicKey) *Signer {
+	return &Signer{Ctx: ctx, Session: session, Handle: handle, Pub: pub}
+}
+
+// Public returns the public half of the token-resident key.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.Pub
+}
+
+// Sign signs digest using the token-resident private key, selecting
+// the RSA-PKCS1v15 or RSA-PSS mechanism based on opts, as required by
+// the SecurityPolicy in use (RSA-PSS for the RsaOaep/RsaPss profiles,
+// PKCS1v15 for the older Basic* profiles).
+//
+// digest is already the hash of the signed message, per crypto.Signer,
+// so PKCS1v15 uses the bare CKM_RSA_PKCS mechanism over a manually
+// built DigestInfo rather than a combined hash-and-sign mechanism like
+// CKM_SHA256_RSA_PKCS, which would hash digest a second time.
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		mech, err := pssMechanism(pss.Hash)
+		if err != nil {
+			return nil, err
+		}
+		return s.sign(mech, digest)
+	}
+
+	prefix, ok := pkcs1v15DigestInfoPrefixes[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("pkcs11: unsupported PKCS1v15 hash %v", opts.HashFunc())
+	}
+	digestInfo := append(append([]byte{}, prefix...), digest...)
+	return s.sign(pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil), digestInfo)
+}
+
+func (s *Signer) sign(mech *pkcs11.Mechanism, data []byte) ([]byte, error) {
+	if err := s.Ctx.SignInit(s.Session, []*pkcs11.Mechanism{mech}, s.Handle); err != nil {
+		return nil, fmt.Errorf("pkcs11: SignInit: %w", err)
+	}
+	return s.Ctx.Sign(s.Session, data)
+}
+
+// pkcs1v15DigestInfoPrefixes holds the DER-encoded DigestInfo prefix
+// for each hash supported by the RSA-PKCS1v15 SecurityPolicy profiles,
+// matching the prefixes crypto/rsa uses internally for SignPKCS1v15.
+var pkcs1v15DigestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+}
+
+// Decrypt decrypts ciphertext using the token-resident private key,
+// selecting the RSA-OAEP or RSA-PKCS1v15 mechanism based on opts.
+func (s *Signer) Decrypt(_ io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if oaep, ok := opts.(*rsa.OAEPOptions); ok {
+		mech, err := oaepMechanism(oaep.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Ctx.DecryptInit(s.Session, []*pkcs11.Mechanism{mech}, s.Handle); err != nil {
+			return nil, fmt.Errorf("pkcs11: DecryptInit: %w", err)
+		}
+		return s.Ctx.Decrypt(s.Session, ciphertext)
+	}
+
+	if err := s.Ctx.DecryptInit(s.Session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}, s.Handle); err != nil {
+		return nil, fmt.Errorf("pkcs11: DecryptInit: %w", err)
+	}
+	return s.Ctx.Decrypt(s.Session, ciphertext)
+}
+
+func pssMechanism(hash crypto.Hash) (*pkcs11.Mechanism, error) {
+	var hashAlg, mgf, hLen uint
+	switch hash {
+	case crypto.SHA1:
+		hashAlg, mgf, hLen = pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1, 20
+	case crypto.SHA256:
+		hashAlg, mgf, hLen = pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, 32
+	case crypto.SHA384:
+		hashAlg, mgf, hLen = pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384, 48
+	default:
+		return nil, fmt.Errorf("pkcs11: unsupported PSS hash %v", hash)
+	}
+	return pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(hashAlg, mgf, hLen)), nil
+}
+
+func oaepMechanism(hash crypto.Hash) (*pkcs11.Mechanism, error) {
+	var hashAlg, mgf uint
+	switch hash {
+	case crypto.SHA1:
+		hashAlg, mgf = pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1
+	case crypto.SHA256:
+		hashAlg, mgf = pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256
+	default:
+		return nil, fmt.Errorf("pkcs11: unsupported OAEP hash %v", hash)
+	}
+	return pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, pkcs11.NewOAEPParams(hashAlg, mgf, pkcs11.CKZ_DATA_SPECIFIED, nil)), nil
+}
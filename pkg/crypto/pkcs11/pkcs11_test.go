@@ -0,0 +1,59 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	"testing"
+)
+
+// TestPKCS1v15DigestInfoPrefixes checks pkcs1v15DigestInfoPrefixes
+// against crypto/rsa's own PKCS1v15 DigestInfo encoding, using the
+// crypto/rsa convention that passing hash 0 to SignPKCS1v15 signs the
+// given bytes directly with no prefix added. Signing prefix+digest
+// this way and verifying it as a real SHA1/SHA256 signature proves the
+// prefix is the exact DER encoding crypto/rsa expects for that hash,
+// without needing real PKCS#11 hardware: this mirrors what
+// Signer.Sign builds by hand for the CKM_RSA_PKCS mechanism.
+func TestPKCS1v15DigestInfoPrefixes(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for hash, prefix := range pkcs1v15DigestInfoPrefixes {
+		t.Run(hash.String(), func(t *testing.T) {
+			h := hash.New()
+			h.Write([]byte("the message"))
+			digest := h.Sum(nil)
+
+			digestInfo := append(append([]byte{}, prefix...), digest...)
+			sig, err := rsa.SignPKCS1v15(rand.Reader, key, 0, digestInfo)
+			if err != nil {
+				t.Fatalf("SignPKCS1v15 with raw DigestInfo: %v", err)
+			}
+
+			if err := rsa.VerifyPKCS1v15(&key.PublicKey, hash, digest, sig); err != nil {
+				t.Fatalf("signature built from prefix %x did not verify as a real %v signature: %v", prefix, hash, err)
+			}
+		})
+	}
+}
+
+// TestPKCS1v15DigestInfoPrefixesCoverRequiredHashes checks that every
+// hash the RSA-PKCS1v15 SecurityPolicy profiles require (Basic128Rsa15,
+// Basic256, and Basic256Sha256's signature, all SHA1 or SHA256) has a
+// prefix registered.
+func TestPKCS1v15DigestInfoPrefixesCoverRequiredHashes(t *testing.T) {
+	for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256} {
+		if _, ok := pkcs1v15DigestInfoPrefixes[hash]; !ok {
+			t.Errorf("missing DigestInfo prefix for %v", hash)
+		}
+	}
+}
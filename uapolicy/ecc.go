@@ -0,0 +1,382 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package uapolicy
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// EphemeralKeyPair is a freshly generated ECDH key pair for one side of
+// an ECC-based SecurityPolicy's key agreement. Generate one with
+// GenerateEphemeralKeyPair, send Bytes() to the peer, and pass it to
+// AsymmetricKeyPair together with the peer's own ephemeral key (received
+// the same way) in KeyPair.RemoteEphemeral.
+//
+// Ephemeral keys are always generated in process memory, even when
+// KeyPair.Local is backed by an HSM or KMS: the long-term identity key
+// is only ever used to sign the handshake, never for the ECDH itself, so
+// AsymmetricKeyPair works with a pluggable crypto.Signer without needing
+// an HSM-backed ECDH operation, which no Go stdlib interface models.
+type EphemeralKeyPair struct {
+	key *ecdh.PrivateKey
+}
+
+// Bytes returns the ephemeral public key to send to the peer.
+func (e *EphemeralKeyPair) Bytes() []byte {
+	return e.key.PublicKey().Bytes()
+}
+
+// GenerateEphemeralKeyPair generates a fresh ephemeral ECDH key pair for
+// the curve used by the given ECC-based SecurityPolicy.
+func GenerateEphemeralKeyPair(uri string) (*EphemeralKeyPair, error) {
+	curve, err := eccCurve(uri)
+	if err != nil {
+		return nil, err
+	}
+	key, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("uapolicy: %s: generate ephemeral key: %w", uri, err)
+	}
+	return &EphemeralKeyPair{key: key}, nil
+}
+
+// eccCurve returns the crypto/ecdh curve used by uri's ECDH key
+// agreement, or an error if uri is not an ECC-based SecurityPolicy with
+// a curve implemented by crypto/ecdh.
+func eccCurve(uri string) (ecdh.Curve, error) {
+	switch uri {
+	case SecurityPolicyAes128Sha256NistP256:
+		return ecdh.P256(), nil
+	case SecurityPolicyAes256Sha384NistP384:
+		return ecdh.P384(), nil
+	case SecurityPolicyChaCha20Poly1305:
+		return ecdh.X25519(), nil
+	case SecurityPolicyAes128Sha256BrainpoolP256r1, SecurityPolicyAes256Sha384BrainpoolP384r1:
+		return nil, fmt.Errorf("uapolicy: %s: brainpool curves require an external curve implementation, not yet wired up", uri)
+	default:
+		return nil, fmt.Errorf("uapolicy: %s: not an ECC key-agreement policy", uri)
+	}
+}
+
+// newAes128Sha256NistP256Asymmetric implements the Aes128_Sha256_nistP256
+// profile: ECDH key agreement on the NIST P-256 curve, HKDF-SHA256 key
+// derivation, AES-128-GCM for confidentiality, and ECDSA-SHA256 for the
+// asymmetric signature.
+func newAes128Sha256NistP256Asymmetric(kp KeyPair, local *EphemeralKeyPair) (*EncryptionAlgorithm, error) {
+	return newEccAsymmetric(kp, local, ecdh.P256(), sha256.New, crypto.SHA256, 16, 32, SecurityPolicyAes128Sha256NistP256)
+}
+
+// newAes256Sha384NistP384Asymmetric implements the Aes256_Sha384_nistP384
+// profile: ECDH key agreement on the NIST P-384 curve, HKDF-SHA384 key
+// derivation, AES-256-GCM for confidentiality, and ECDSA-SHA384 for the
+// asymmetric signature.
+func newAes256Sha384NistP384Asymmetric(kp KeyPair, local *EphemeralKeyPair) (*EncryptionAlgorithm, error) {
+	return newEccAsymmetric(kp, local, ecdh.P384(), sha512.New384, crypto.SHA384, 32, 48, SecurityPolicyAes256Sha384NistP384)
+}
+
+// newEccAsymmetric is shared by the NIST-curve profiles. The shared
+// secret comes from an ephemeral-ephemeral ECDH between local (generated
+// by GenerateEphemeralKeyPair) and kp.RemoteEphemeral, the peer's own
+// ephemeral public key received the same way: both sides land on the
+// same secret because ECDH(a, B) == ECDH(b, A) regardless of which side
+// is called "local". kp.Local/kp.Remote, the long-term identity keys,
+// are used only to sign and verify the handshake, independent of the
+// ECDH agreement.
+func newEccAsymmetric(kp KeyPair, local *EphemeralKeyPair, curve ecdh.Curve, hashFn func() hash.Hash, signHash crypto.Hash, aeadKeyLen, coordSize int, uri string) (*EncryptionAlgorithm, error) {
+	remotePub, ok := kp.Remote.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("uapolicy: %s: remote key must be an *ecdsa.PublicKey, got %T", uri, kp.Remote)
+	}
+	if local == nil {
+		return nil, fmt.Errorf("uapolicy: %s: local ephemeral key is required; call GenerateEphemeralKeyPair first", uri)
+	}
+	if len(kp.RemoteEphemeral) == 0 {
+		return nil, fmt.Errorf("uapolicy: %s: RemoteEphemeral is required to complete ECDH key agreement", uri)
+	}
+
+	remoteEphemeral, err := curve.NewPublicKey(kp.RemoteEphemeral)
+	if err != nil {
+		return nil, fmt.Errorf("uapolicy: %s: remote ephemeral key: %w", uri, err)
+	}
+
+	shared, err := local.key.ECDH(remoteEphemeral)
+	if err != nil {
+		return nil, fmt.Errorf("uapolicy: %s: ECDH: %w", uri, err)
+	}
+
+	aead, err := aeadFromSharedSecret(shared, hashFn, aeadKeyLen, newAESGCM)
+	if err != nil {
+		return nil, fmt.Errorf("uapolicy: %s: %w", uri, err)
+	}
+
+	return &EncryptionAlgorithm{
+		blockSize:           1,
+		plainttextBlockSize: 1,
+		encrypt:             &aeadCrypt{aead: aead},
+		decrypt:             &aeadCrypt{aead: aead},
+		signature:           &ecdsaSign{signer: kp.Local, hashFn: hashFn, hash: signHash, size: coordSize},
+		verifySignature:     &ecdsaVerify{key: remotePub, hashFn: hashFn, size: coordSize},
+		signatureLength:     2 * coordSize,
+		encryptionURI:       uri,
+		signatureURI:        uri,
+		ephemeralKey:        local.Bytes(),
+	}, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newAes128Sha256NistP256Symmetric derives an AES-128-GCM key from the
+// client/server nonces via HKDF-SHA256. The AEAD's own authentication
+// tag covers integrity, so no separate symmetric signature is used.
+func newAes128Sha256NistP256Symmetric(localNonce, remoteNonce []byte) (*EncryptionAlgorithm, error) {
+	return newEccSymmetric(localNonce, remoteNonce, sha256.New, 16, newAESGCM, SecurityPolicyAes128Sha256NistP256)
+}
+
+// newAes256Sha384NistP384Symmetric derives an AES-256-GCM key from the
+// client/server nonces via HKDF-SHA384.
+func newAes256Sha384NistP384Symmetric(localNonce, remoteNonce []byte) (*EncryptionAlgorithm, error) {
+	return newEccSymmetric(localNonce, remoteNonce, sha512.New384, 32, newAESGCM, SecurityPolicyAes256Sha384NistP384)
+}
+
+// newChaCha20Poly1305Symmetric derives a ChaCha20-Poly1305 key from the
+// client/server nonces via HKDF-SHA256.
+func newChaCha20Poly1305Symmetric(localNonce, remoteNonce []byte) (*EncryptionAlgorithm, error) {
+	return newEccSymmetric(localNonce, remoteNonce, sha256.New, chacha20poly1305.KeySize, chacha20poly1305.New, SecurityPolicyChaCha20Poly1305)
+}
+
+// newEccSymmetric is shared by the HKDF/AEAD-based symmetric policies.
+// The encrypt and decrypt keys are derived independently, each from one
+// side's nonce alone rather than a concatenation of both: side A's
+// encrypt key is derived from A's own nonce, and side B's decrypt key
+// is derived from the same nonce (received as B's remoteNonce), so the
+// two match. Deriving a single key from localNonce||remoteNonce instead
+// would depend on concatenation order, which differs between the two
+// sides (A sees local‖remote, B sees remote‖local) and so never agrees.
+func newEccSymmetric(localNonce, remoteNonce []byte, hashFn func() hash.Hash, keyLen int, newAEAD func([]byte) (cipher.AEAD, error), uri string) (*EncryptionAlgorithm, error) {
+	encryptAEAD, err := aeadFromSharedSecret(localNonce, hashFn, keyLen, newAEAD)
+	if err != nil {
+		return nil, fmt.Errorf("uapolicy: %s: derive encrypt key: %w", uri, err)
+	}
+	decryptAEAD, err := aeadFromSharedSecret(remoteNonce, hashFn, keyLen, newAEAD)
+	if err != nil {
+		return nil, fmt.Errorf("uapolicy: %s: derive decrypt key: %w", uri, err)
+	}
+
+	return &EncryptionAlgorithm{
+		blockSize:           1,
+		plainttextBlockSize: 1,
+		encrypt:             &aeadCrypt{aead: encryptAEAD},
+		decrypt:             &aeadCrypt{aead: decryptAEAD},
+		encryptionURI:       uri,
+	}, nil
+}
+
+// newChaCha20Poly1305Asymmetric implements the ChaCha20Poly1305 profile:
+// X25519 ECDH key agreement between ephemeral keys (see newEccAsymmetric),
+// HKDF-SHA256 key derivation, ChaCha20-Poly1305 for confidentiality, and
+// Ed25519 for the asymmetric signature using the peer's and the local
+// long-term Ed25519 identity keys.
+func newChaCha20Poly1305Asymmetric(kp KeyPair, local *EphemeralKeyPair) (*EncryptionAlgorithm, error) {
+	remotePub, ok := kp.Remote.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("uapolicy: %s: remote key must be an ed25519.PublicKey, got %T", SecurityPolicyChaCha20Poly1305, kp.Remote)
+	}
+	if local == nil {
+		return nil, fmt.Errorf("uapolicy: %s: local ephemeral key is required; call GenerateEphemeralKeyPair first", SecurityPolicyChaCha20Poly1305)
+	}
+	if len(kp.RemoteEphemeral) == 0 {
+		return nil, fmt.Errorf("uapolicy: %s: RemoteEphemeral is required to complete ECDH key agreement", SecurityPolicyChaCha20Poly1305)
+	}
+
+	remoteEphemeral, err := ecdh.X25519().NewPublicKey(kp.RemoteEphemeral)
+	if err != nil {
+		return nil, fmt.Errorf("uapolicy: %s: remote ephemeral key: %w", SecurityPolicyChaCha20Poly1305, err)
+	}
+
+	shared, err := local.key.ECDH(remoteEphemeral)
+	if err != nil {
+		return nil, fmt.Errorf("uapolicy: %s: ECDH: %w", SecurityPolicyChaCha20Poly1305, err)
+	}
+
+	aead, err := aeadFromSharedSecret(shared, sha256.New, chacha20poly1305.KeySize, chacha20poly1305.New)
+	if err != nil {
+		return nil, fmt.Errorf("uapolicy: %s: %w", SecurityPolicyChaCha20Poly1305, err)
+	}
+
+	return &EncryptionAlgorithm{
+		blockSize:           1,
+		plainttextBlockSize: 1,
+		encrypt:             &aeadCrypt{aead: aead},
+		decrypt:             &aeadCrypt{aead: aead},
+		signature:           &ed25519Sign{signer: kp.Local},
+		verifySignature:     &ed25519Verify{key: remotePub},
+		signatureLength:     ed25519.SignatureSize,
+		encryptionURI:       SecurityPolicyChaCha20Poly1305,
+		signatureURI:        SecurityPolicyChaCha20Poly1305,
+		ephemeralKey:        local.Bytes(),
+	}, nil
+}
+
+// aeadFromSharedSecret derives a keyLen-byte key from an ECDH shared
+// secret with HKDF (using hashFn as the underlying hash) and builds the
+// AEAD cipher returned by newAEAD from it.
+func aeadFromSharedSecret(shared []byte, hashFn func() hash.Hash, keyLen int, newAEAD func([]byte) (cipher.AEAD, error)) (cipher.AEAD, error) {
+	kdf := hkdf.New(hashFn, shared, nil, []byte("opcua-ecc-key"))
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, fmt.Errorf("derive key: %w", err)
+	}
+	return newAEAD(key)
+}
+
+// aeadCrypt implements the Encrypt/Decrypt interfaces used by
+// EncryptionAlgorithm on top of a cipher.AEAD. The random nonce used
+// for each Encrypt call is prepended to the returned ciphertext so
+// Decrypt can recover it.
+type aeadCrypt struct {
+	aead cipher.AEAD
+}
+
+func (a *aeadCrypt) Encrypt(cleartext []byte) ([]byte, error) {
+	nonce := make([]byte, a.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return a.aead.Seal(nonce, nonce, cleartext, nil), nil
+}
+
+func (a *aeadCrypt) Decrypt(ciphertext []byte) ([]byte, error) {
+	n := a.aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, errors.New("ciphertext shorter than nonce")
+	}
+	return a.aead.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+}
+
+// ecdsaSign implements the Signature interface used by EncryptionAlgorithm.
+// It signs through signer (a crypto.Signer, so an HSM/KMS-backed identity
+// key works here too), then re-encodes the ASN.1 DER signature signer
+// returns into the fixed-width r||s format Part 7 requires on the wire,
+// sized for the curve's coordinate size.
+type ecdsaSign struct {
+	signer crypto.Signer
+	hashFn func() hash.Hash
+	hash   crypto.Hash
+	size   int
+}
+
+func (s *ecdsaSign) Signature(message []byte) ([]byte, error) {
+	h := s.hashFn()
+	h.Write(message)
+	der, err := s.signer.Sign(rand.Reader, h.Sum(nil), s.hash)
+	if err != nil {
+		return nil, err
+	}
+	return ecdsaDERToFixed(der, s.size)
+}
+
+// ecdsaVerify implements the Verify interface used by EncryptionAlgorithm.
+// It converts the fixed-width r||s signature back to ASN.1 DER so it can
+// verify with the standard library's ecdsa.VerifyASN1.
+type ecdsaVerify struct {
+	key    *ecdsa.PublicKey
+	hashFn func() hash.Hash
+	size   int
+}
+
+func (v *ecdsaVerify) Verify(message, signature []byte) error {
+	der, err := ecdsaFixedToDER(signature, v.size)
+	if err != nil {
+		return err
+	}
+	h := v.hashFn()
+	h.Write(message)
+	if !ecdsa.VerifyASN1(v.key, h.Sum(nil), der) {
+		return errors.New("ecdsa: invalid signature")
+	}
+	return nil
+}
+
+// ecdsaASN1Signature is the ASN.1 structure crypto/ecdsa encodes its
+// SignASN1/VerifyASN1 (and crypto.Signer.Sign) signatures as.
+type ecdsaASN1Signature struct {
+	R, S *big.Int
+}
+
+// ecdsaDERToFixed re-encodes an ASN.1 DER ECDSA signature as the
+// fixed-width big-endian r||s format Part 7 requires on the wire, each
+// of size bytes.
+func ecdsaDERToFixed(der []byte, size int) ([]byte, error) {
+	var sig ecdsaASN1Signature
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("ecdsa: parse signature: %w", err)
+	}
+	out := make([]byte, 2*size)
+	sig.R.FillBytes(out[:size])
+	sig.S.FillBytes(out[size:])
+	return out, nil
+}
+
+// ecdsaFixedToDER converts a fixed-width big-endian r||s ECDSA signature
+// back to ASN.1 DER for verification with ecdsa.VerifyASN1.
+func ecdsaFixedToDER(raw []byte, size int) ([]byte, error) {
+	if len(raw) != 2*size {
+		return nil, fmt.Errorf("ecdsa: signature must be %d bytes, got %d", 2*size, len(raw))
+	}
+	sig := ecdsaASN1Signature{
+		R: new(big.Int).SetBytes(raw[:size]),
+		S: new(big.Int).SetBytes(raw[size:]),
+	}
+	return asn1.Marshal(sig)
+}
+
+// ed25519Sign implements the Signature interface used by EncryptionAlgorithm.
+// It signs through signer (a crypto.Signer), so an HSM/KMS-backed Ed25519
+// identity works here too.
+type ed25519Sign struct {
+	signer crypto.Signer
+}
+
+func (s *ed25519Sign) Signature(message []byte) ([]byte, error) {
+	// crypto.Hash(0) selects pure Ed25519 (not Ed25519ph/ctx), for which
+	// crypto.Signer's digest parameter is the message itself, per the
+	// ed25519.PrivateKey.Sign doc comment.
+	return s.signer.Sign(rand.Reader, message, crypto.Hash(0))
+}
+
+// ed25519Verify implements the Verify interface used by EncryptionAlgorithm.
+type ed25519Verify struct {
+	key ed25519.PublicKey
+}
+
+func (v *ed25519Verify) Verify(message, signature []byte) error {
+	if !ed25519.Verify(v.key, message, signature) {
+		return errors.New("ed25519: invalid signature")
+	}
+	return nil
+}
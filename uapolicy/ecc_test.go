@@ -0,0 +1,183 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package uapolicy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+)
+
+// TestSymmetricRoundTrip exercises Symmetric exactly as both sides of a
+// handshake would call it: each passes (own nonce, peer's nonce) in that
+// order, so a client calls Symmetric(clientNonce, serverNonce) and a
+// server calls Symmetric(serverNonce, clientNonce) - the opposite order.
+// What one side encrypts, the other must be able to decrypt.
+//
+// This, TestECDHHandshakeBothSides, and the other tests in this file
+// only exercise the three ECC policies against themselves. None of them
+// are run against a second OPC-UA stack (e.g. open62541), so interop
+// with real-world peers for these policies remains unverified; that is
+// a known gap, not an oversight, and should be closed before relying on
+// these policies against third-party servers.
+func TestSymmetricRoundTrip(t *testing.T) {
+	for _, uri := range []string{
+		SecurityPolicyAes128Sha256NistP256,
+		SecurityPolicyAes256Sha384NistP384,
+		SecurityPolicyChaCha20Poly1305,
+	} {
+		t.Run(uri, func(t *testing.T) {
+			clientNonce := make([]byte, 32)
+			serverNonce := make([]byte, 32)
+			if _, err := rand.Read(clientNonce); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := rand.Read(serverNonce); err != nil {
+				t.Fatal(err)
+			}
+
+			clientAlgo, err := Symmetric(uri, clientNonce, serverNonce)
+			if err != nil {
+				t.Fatalf("client Symmetric: %v", err)
+			}
+			serverAlgo, err := Symmetric(uri, serverNonce, clientNonce)
+			if err != nil {
+				t.Fatalf("server Symmetric: %v", err)
+			}
+
+			const msg = "post-handshake message"
+			ciphertext, err := clientAlgo.Encrypt([]byte(msg))
+			if err != nil {
+				t.Fatalf("client Encrypt: %v", err)
+			}
+			plaintext, err := serverAlgo.Decrypt(ciphertext)
+			if err != nil {
+				t.Fatalf("server could not decrypt the client's message: %v", err)
+			}
+			if string(plaintext) != msg {
+				t.Fatalf("decrypted message = %q, want %q", plaintext, msg)
+			}
+
+			const reply = "post-handshake reply"
+			ciphertext, err = serverAlgo.Encrypt([]byte(reply))
+			if err != nil {
+				t.Fatalf("server Encrypt: %v", err)
+			}
+			plaintext, err = clientAlgo.Decrypt(ciphertext)
+			if err != nil {
+				t.Fatalf("client could not decrypt the server's message: %v", err)
+			}
+			if string(plaintext) != reply {
+				t.Fatalf("decrypted message = %q, want %q", plaintext, reply)
+			}
+		})
+	}
+}
+
+func TestEcdsaFixedDERRoundTrip(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := bytes.Repeat([]byte{0x42}, 32)
+	der, err := ecdsa.SignASN1(rand.Reader, key, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixed, err := ecdsaDERToFixed(der, 32)
+	if err != nil {
+		t.Fatalf("ecdsaDERToFixed: %v", err)
+	}
+	if len(fixed) != 64 {
+		t.Fatalf("fixed-width signature length = %d, want 64", len(fixed))
+	}
+
+	roundTripped, err := ecdsaFixedToDER(fixed, 32)
+	if err != nil {
+		t.Fatalf("ecdsaFixedToDER: %v", err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest, roundTripped) {
+		t.Fatal("signature re-encoded from fixed-width form did not verify")
+	}
+}
+
+func TestEcdsaFixedToDERRejectsWrongLength(t *testing.T) {
+	if _, err := ecdsaFixedToDER(make([]byte, 63), 32); err == nil {
+		t.Fatal("expected an error for a signature of the wrong length")
+	}
+}
+
+// TestECDHHandshakeBothSides exercises AsymmetricKeyPair exactly as both
+// sides of a real handshake would call it: each generates its own
+// ephemeral key pair, exchanges the public halves and remote identity
+// keys, and the two resulting EncryptionAlgorithms must derive the same
+// secret so that either side can decrypt what the other encrypted and
+// verify what the other signed.
+func TestECDHHandshakeBothSides(t *testing.T) {
+	clientIdentity, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverIdentity, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientEphemeral, err := GenerateEphemeralKeyPair(SecurityPolicyAes128Sha256NistP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverEphemeral, err := GenerateEphemeralKeyPair(SecurityPolicyAes128Sha256NistP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientAlgo, err := AsymmetricKeyPair(SecurityPolicyAes128Sha256NistP256, KeyPair{
+		Local:           clientIdentity,
+		Remote:          &serverIdentity.PublicKey,
+		RemoteEphemeral: serverEphemeral.Bytes(),
+	}, clientEphemeral)
+	if err != nil {
+		t.Fatalf("client AsymmetricKeyPair: %v", err)
+	}
+
+	serverAlgo, err := AsymmetricKeyPair(SecurityPolicyAes128Sha256NistP256, KeyPair{
+		Local:           serverIdentity,
+		Remote:          &clientIdentity.PublicKey,
+		RemoteEphemeral: clientEphemeral.Bytes(),
+	}, serverEphemeral)
+	if err != nil {
+		t.Fatalf("server AsymmetricKeyPair: %v", err)
+	}
+
+	const msg = "hello from the client"
+	ciphertext, err := clientAlgo.Encrypt([]byte(msg))
+	if err != nil {
+		t.Fatalf("client Encrypt: %v", err)
+	}
+	plaintext, err := serverAlgo.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("server could not decrypt the client's message, shared secrets don't match: %v", err)
+	}
+	if string(plaintext) != msg {
+		t.Fatalf("decrypted message = %q, want %q", plaintext, msg)
+	}
+
+	const handshakeData = "client hello handshake"
+	sig, err := clientAlgo.Signature([]byte(handshakeData))
+	if err != nil {
+		t.Fatalf("client Signature: %v", err)
+	}
+	if got, want := len(sig), clientAlgo.SignatureLength(); got != want {
+		t.Fatalf("signature length = %d, SignatureLength() = %d", got, want)
+	}
+	if err := serverAlgo.VerifySignature([]byte(handshakeData), sig); err != nil {
+		t.Fatalf("server could not verify the client's signature: %v", err)
+	}
+}
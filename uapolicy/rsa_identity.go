@@ -0,0 +1,150 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package uapolicy
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	"fmt"
+)
+
+// rsaScheme describes the RSA signature and encryption scheme a
+// SecurityPolicy profile specifies in Part 7, independent of whether
+// the local private key is held in process memory or behind a
+// crypto.Signer/crypto.Decrypter such as an HSM or cloud KMS.
+type rsaScheme struct {
+	signHash crypto.Hash
+	signPSS  bool        // PSS with salt length == hash length, instead of PKCS1v15
+	encHash  crypto.Hash // 0 selects raw PKCS1v15 encryption instead of OAEP
+}
+
+// rsaSchemes maps each RSA-based SecurityPolicy URI to the scheme it
+// specifies, so newRSAAsymmetricIdentity can drive a generic
+// crypto.Signer/crypto.Decrypter through the right operations without
+// needing a concrete *rsa.PrivateKey.
+var rsaSchemes = map[string]rsaScheme{
+	SecurityPolicyBasic128Rsa15:       {signHash: crypto.SHA1},
+	SecurityPolicyBasic256:            {signHash: crypto.SHA1, encHash: crypto.SHA1},
+	SecurityPolicyBasic256Sha256:      {signHash: crypto.SHA256, encHash: crypto.SHA1},
+	SecurityPolicyAes128Sha256RsaOaep: {signHash: crypto.SHA256, encHash: crypto.SHA1},
+	SecurityPolicyAes256Sha256RsaPss:  {signHash: crypto.SHA256, signPSS: true, encHash: crypto.SHA256},
+}
+
+// newRSAAsymmetricIdentity builds the asymmetric EncryptionAlgorithm for
+// uri directly on top of local's crypto.Signer/crypto.Decrypter, for RSA
+// identities whose private key cannot be extracted into a concrete
+// *rsa.PrivateKey (e.g. pkg/crypto/pkcs11). It is the fallback
+// AsymmetricIdentity reaches for once local fails to unwrap to a
+// *rsa.PrivateKey directly or via rsaPrivateKeyer.
+func newRSAAsymmetricIdentity(uri string, local SignerDecrypter, remoteKey *rsa.PublicKey) (*EncryptionAlgorithm, error) {
+	scheme, ok := rsaSchemes[uri]
+	if !ok {
+		return nil, fmt.Errorf("security policy %s: local key of type %T cannot be used; its private key must be extractable (directly or via RSAPrivateKey())", uri, local)
+	}
+
+	localPub, ok := local.Public().(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("security policy %s: local key's public half must be *rsa.PublicKey, got %T", uri, local.Public())
+	}
+
+	encOverhead := 11 // PKCS1v15 encryption overhead
+	if scheme.encHash != 0 {
+		encOverhead = 2*scheme.encHash.Size() + 2 // OAEP overhead
+	}
+	blockSize := remoteKey.Size()
+	plaintextBlockSize := blockSize - encOverhead
+
+	return &EncryptionAlgorithm{
+		blockSize:           blockSize,
+		plainttextBlockSize: plaintextBlockSize,
+		encrypt:             &rsaEncrypt{key: remoteKey, scheme: scheme},
+		decrypt:             &rsaDecrypt{decrypter: local, scheme: scheme},
+		signature:           &rsaSign{signer: local, scheme: scheme},
+		verifySignature:     &rsaVerify{key: remoteKey, scheme: scheme},
+		signatureLength:     localPub.Size(),
+		nonceLength:         32,
+		encryptionURI:       uri,
+		signatureURI:        uri,
+	}, nil
+}
+
+// rsaEncrypt implements the Encrypt interface used by EncryptionAlgorithm
+// on top of a plain RSA public key, which is always available in memory
+// (it comes from the peer's certificate), so it needs no crypto.Decrypter
+// indirection.
+type rsaEncrypt struct {
+	key    *rsa.PublicKey
+	scheme rsaScheme
+}
+
+func (e *rsaEncrypt) Encrypt(cleartext []byte) ([]byte, error) {
+	if e.scheme.encHash == 0 {
+		return rsa.EncryptPKCS1v15(rand.Reader, e.key, cleartext)
+	}
+	return rsa.EncryptOAEP(e.scheme.encHash.New(), rand.Reader, e.key, cleartext, nil)
+}
+
+// rsaDecrypt implements the Decrypt interface used by EncryptionAlgorithm
+// on top of a crypto.Decrypter, so the private key can stay inside an
+// HSM or KMS.
+type rsaDecrypt struct {
+	decrypter crypto.Decrypter
+	scheme    rsaScheme
+}
+
+func (d *rsaDecrypt) Decrypt(ciphertext []byte) ([]byte, error) {
+	var opts crypto.DecrypterOpts
+	if d.scheme.encHash == 0 {
+		opts = &rsa.PKCS1v15DecryptOptions{}
+	} else {
+		opts = &rsa.OAEPOptions{Hash: d.scheme.encHash}
+	}
+	return d.decrypter.Decrypt(rand.Reader, ciphertext, opts)
+}
+
+// rsaSign implements the Signature interface used by EncryptionAlgorithm
+// on top of a crypto.Signer, so the private key can stay inside an HSM
+// or KMS.
+type rsaSign struct {
+	signer crypto.Signer
+	scheme rsaScheme
+}
+
+func (s *rsaSign) Signature(message []byte) ([]byte, error) {
+	h := s.scheme.signHash.New()
+	h.Write(message)
+	digest := h.Sum(nil)
+
+	if s.scheme.signPSS {
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: s.scheme.signHash}
+		return s.signer.Sign(rand.Reader, digest, opts)
+	}
+	return s.signer.Sign(rand.Reader, digest, s.scheme.signHash)
+}
+
+// rsaVerify implements the Verify interface used by EncryptionAlgorithm
+// on top of a plain RSA public key.
+type rsaVerify struct {
+	key    *rsa.PublicKey
+	scheme rsaScheme
+}
+
+func (v *rsaVerify) Verify(message, signature []byte) error {
+	h := v.scheme.signHash.New()
+	h.Write(message)
+	digest := h.Sum(nil)
+
+	if v.scheme.signPSS {
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: v.scheme.signHash}
+		return rsa.VerifyPSS(v.key, v.scheme.signHash, digest, signature, opts)
+	}
+	if err := rsa.VerifyPKCS1v15(v.key, v.scheme.signHash, digest, signature); err != nil {
+		return fmt.Errorf("rsa: invalid signature: %w", err)
+	}
+	return nil
+}
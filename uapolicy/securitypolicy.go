@@ -4,10 +4,12 @@
 
 // Package uapolicy implements the encryption, decryption, signing,
 // and signature verifying algorithms for Security Policy profiles as
-// defined in Part 7 of the OPC-UA specifications (version 1.04)
+// defined in Part 7 of the OPC-UA specifications (version 1.04, plus
+// the ECC profiles added in 1.05)
 package uapolicy
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"errors"
 	"fmt"
@@ -15,13 +17,18 @@ import (
 )
 
 const (
-	SecurityPolicyURL                 = "http://opcfoundation.org/UA/SecurityPolicy#"
-	SecurityPolicyNone                = "http://opcfoundation.org/UA/SecurityPolicy#None"
-	SecurityPolicyBasic128Rsa15       = "http://opcfoundation.org/UA/SecurityPolicy#Basic128Rsa15"
-	SecurityPolicyBasic256            = "http://opcfoundation.org/UA/SecurityPolicy#Basic256"
-	SecurityPolicyBasic256Sha256      = "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
-	SecurityPolicyAes128Sha256RsaOaep = "http://opcfoundation.org/UA/SecurityPolicy#Aes128_Sha256_RsaOaep"
-	SecurityPolicyAes256Sha256RsaPss  = "http://opcfoundation.org/UA/SecurityPolicy#Aes256_Sha256_RsaPss"
+	SecurityPolicyURL                         = "http://opcfoundation.org/UA/SecurityPolicy#"
+	SecurityPolicyNone                        = "http://opcfoundation.org/UA/SecurityPolicy#None"
+	SecurityPolicyBasic128Rsa15               = "http://opcfoundation.org/UA/SecurityPolicy#Basic128Rsa15"
+	SecurityPolicyBasic256                    = "http://opcfoundation.org/UA/SecurityPolicy#Basic256"
+	SecurityPolicyBasic256Sha256              = "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+	SecurityPolicyAes128Sha256RsaOaep         = "http://opcfoundation.org/UA/SecurityPolicy#Aes128_Sha256_RsaOaep"
+	SecurityPolicyAes256Sha256RsaPss          = "http://opcfoundation.org/UA/SecurityPolicy#Aes256_Sha256_RsaPss"
+	SecurityPolicyAes128Sha256NistP256        = "http://opcfoundation.org/UA/SecurityPolicy#Aes128_Sha256_nistP256"
+	SecurityPolicyAes256Sha384NistP384        = "http://opcfoundation.org/UA/SecurityPolicy#Aes256_Sha384_nistP384"
+	SecurityPolicyAes128Sha256BrainpoolP256r1 = "http://opcfoundation.org/UA/SecurityPolicy#Aes128_Sha256_brainpoolP256r1"
+	SecurityPolicyAes256Sha384BrainpoolP384r1 = "http://opcfoundation.org/UA/SecurityPolicy#Aes256_Sha384_brainpoolP384r1"
+	SecurityPolicyChaCha20Poly1305            = "http://opcfoundation.org/UA/SecurityPolicy#ChaCha20Poly1305"
 )
 
 // SupportedPolicies returns all supported Security Policies
@@ -35,18 +42,135 @@ func SupportedPolicies() []string {
 	return uris
 }
 
-// Asymmetric returns the asymmetric encryption algorithm for the given security policy.
+// Asymmetric returns the asymmetric encryption algorithm for the given
+// security policy. It is a thin wrapper around AsymmetricIdentity for
+// callers who already hold the local private key in memory.
 func Asymmetric(uri string, localKey *rsa.PrivateKey, remoteKey *rsa.PublicKey) (*EncryptionAlgorithm, error) {
+	var local SignerDecrypter
+	if localKey != nil {
+		local = localKey
+	}
+	return AsymmetricIdentity(uri, local, remoteKey)
+}
+
+// SignerDecrypter is the interface a local asymmetric identity must
+// implement to use AsymmetricIdentity: Sign for the RSA-PSS/PKCS1v15
+// asymmetric signature, and Decrypt for unwrapping the peer's
+// RSA-OAEP/PKCS1v15-encrypted nonce. *rsa.PrivateKey satisfies this,
+// as does any PKCS#11/KMS-backed key that keeps the private key inside
+// hardware (see pkg/crypto/pkcs11 and pkg/crypto/file).
+type SignerDecrypter interface {
+	crypto.Signer
+	crypto.Decrypter
+}
+
+// rsaPrivateKeyer is implemented by SignerDecrypter adapters that are
+// backed by an in-memory RSA key (e.g. pkg/crypto/file) so that
+// AsymmetricIdentity can bridge them into the RSA-specific code paths
+// below, which have not yet been generalized to call Sign/Decrypt
+// directly on every policy.
+type rsaPrivateKeyer interface {
+	RSAPrivateKey() *rsa.PrivateKey
+}
+
+// AsymmetricIdentity returns the asymmetric encryption algorithm for
+// the given security policy using a pluggable local identity. local
+// must implement SignerDecrypter; this allows the private key to live
+// in an HSM or cloud KMS rather than in process memory.
+//
+// The per-policy RSA-PSS/RSA-OAEP/PKCS1v15 implementations still
+// operate on a concrete *rsa.PrivateKey internally, so when local is
+// one directly, or implements rsaPrivateKeyer (as pkg/crypto/file
+// does), those are used unchanged. For a genuinely non-extractable key
+// such as one backed by pkg/crypto/pkcs11, AsymmetricIdentity instead
+// builds the algorithm generically on top of local's Sign/Decrypt
+// methods (see newRSAAsymmetricIdentity), driven by the RSA scheme the
+// given uri specifies.
+func AsymmetricIdentity(uri string, local SignerDecrypter, remoteKey *rsa.PublicKey) (*EncryptionAlgorithm, error) {
 	p, ok := policies[uri]
 	if !ok {
 		return nil, fmt.Errorf("unsupported security policy %s", uri)
 	}
 
-	if uri != SecurityPolicyNone && (localKey == nil || remoteKey == nil) {
+	if p.asymmetric == nil {
+		return nil, fmt.Errorf("security policy %s requires AsymmetricKeyPair, not Asymmetric/AsymmetricIdentity", uri)
+	}
+
+	// Resolve local to a concrete *rsa.PrivateKey before checking for
+	// nil: local == nil alone does not catch a typed-nil *rsa.PrivateKey
+	// wrapped in the SignerDecrypter interface (a non-nil interface
+	// value holding a nil pointer), which would otherwise sail past this
+	// check and into newRSAAsymmetricIdentity below, panicking on
+	// local.Public() with a nil receiver.
+	var localKey *rsa.PrivateKey
+	localNil := local == nil
+	switch k := local.(type) {
+	case nil:
+	case *rsa.PrivateKey:
+		localKey = k
+		localNil = k == nil
+	case rsaPrivateKeyer:
+		localKey = k.RSAPrivateKey()
+	}
+
+	if uri != SecurityPolicyNone && (localNil || remoteKey == nil) {
 		return nil, errors.New("invalid asymmetric security policy config: both keys required")
 	}
 
-	return p.asymmetric(localKey, remoteKey)
+	if localKey != nil || local == nil {
+		return p.asymmetric(localKey, remoteKey)
+	}
+
+	return newRSAAsymmetricIdentity(uri, local, remoteKey)
+}
+
+// KeyPair holds the local and remote asymmetric key material for the
+// ECC-based security policies introduced in Part 7 of OPC-UA 1.05.
+// Unlike the RSA policies, which exchange an encrypted nonce via
+// RSA-OAEP, these policies derive their symmetric keys from an
+// ephemeral-ephemeral ECDH key agreement: Local and Remote are the
+// long-term identity keys used only to sign and verify the handshake,
+// while RemoteEphemeral is the peer's ephemeral ECDH public key
+// (generated with GenerateEphemeralKeyPair on their side and sent
+// alongside the signature). AsymmetricKeyPair generates the matching
+// local ephemeral key pair itself; it works identically whichever side
+// calls it first, so there is no separate initiator/responder API.
+type KeyPair struct {
+	Local           crypto.Signer
+	Remote          crypto.PublicKey
+	RemoteEphemeral []byte
+}
+
+// AsymmetricKeyPair returns the asymmetric encryption algorithm for
+// security policies that use ECDH key agreement instead of an RSA
+// nonce exchange (e.g. SecurityPolicyAes128Sha256NistP256). RSA-based
+// policies should continue to use Asymmetric.
+//
+// local is the caller's own ephemeral key pair, generated up front with
+// GenerateEphemeralKeyPair(uri) so that local.Bytes() can be sent to the
+// peer alongside the handshake signature; the returned
+// EncryptionAlgorithm also exposes it via EphemeralKey() for
+// convenience. Both sides of a handshake call AsymmetricKeyPair the
+// same way: generate an ephemeral key pair, exchange ephemeral public
+// keys and signatures with the peer, then call AsymmetricKeyPair with
+// kp.RemoteEphemeral set to what was received. Because ECDH(a, B) and
+// ECDH(b, A) produce the same secret, there is no distinct "initiator"
+// or "responder" code path.
+func AsymmetricKeyPair(uri string, kp KeyPair, local *EphemeralKeyPair) (*EncryptionAlgorithm, error) {
+	p, ok := policies[uri]
+	if !ok {
+		return nil, fmt.Errorf("unsupported security policy %s", uri)
+	}
+
+	if p.asymmetricKeyPair == nil {
+		return nil, fmt.Errorf("security policy %s does not use key-pair based key agreement", uri)
+	}
+
+	if uri != SecurityPolicyNone && (kp.Local == nil || kp.Remote == nil) {
+		return nil, errors.New("invalid asymmetric security policy config: both keys required")
+	}
+
+	return p.asymmetricKeyPair(kp, local)
 }
 
 // Symmetrics returns the symmetric encryption algorithm for the given security policy.
@@ -85,6 +209,7 @@ type EncryptionAlgorithm struct {
 	signatureLength     int
 	encryptionURI       string
 	signatureURI        string
+	ephemeralKey        []byte
 }
 
 // BlockSize returns the underlying encryption algorithm's blocksize.
@@ -164,16 +289,34 @@ func (e *EncryptionAlgorithm) SignatureURI() string {
 	return e.signatureURI
 }
 
+// EphemeralKey returns the local ECDH ephemeral public key to send to
+// the peer, for security policies that perform key agreement instead
+// of an RSA nonce exchange (see KeyPair and AsymmetricKeyPair). It is
+// nil for policies that use a nonce exchange, including all RSA-based
+// policies and SecurityPolicyNone.
+func (e *EncryptionAlgorithm) EphemeralKey() []byte {
+	return e.ephemeralKey
+}
+
 var policies = map[string]policy{
-	SecurityPolicyNone:                {newNoneAsymmetric, newNoneSymmetric},
-	SecurityPolicyBasic128Rsa15:       {newBasic128Rsa15Asymmetric, newBasic128Rsa15Symmetric},
-	SecurityPolicyBasic256:            {newBasic256Asymmetric, newBasic256Symmetric},
-	SecurityPolicyBasic256Sha256:      {newBasic256Rsa256Asymmetric, newBasic256Rsa256Symmetric},
-	SecurityPolicyAes128Sha256RsaOaep: {newAes128Sha256RsaOaepAsymmetric, newAes128Sha256RsaOaepSymmetric},
-	SecurityPolicyAes256Sha256RsaPss:  {newAes256Sha256RsaPssAsymmetric, newAes256Sha256RsaPssSymmetric},
+	SecurityPolicyNone:                        {asymmetric: newNoneAsymmetric, symmetric: newNoneSymmetric},
+	SecurityPolicyBasic128Rsa15:               {asymmetric: newBasic128Rsa15Asymmetric, symmetric: newBasic128Rsa15Symmetric},
+	SecurityPolicyBasic256:                    {asymmetric: newBasic256Asymmetric, symmetric: newBasic256Symmetric},
+	SecurityPolicyBasic256Sha256:              {asymmetric: newBasic256Rsa256Asymmetric, symmetric: newBasic256Rsa256Symmetric},
+	SecurityPolicyAes128Sha256RsaOaep:         {asymmetric: newAes128Sha256RsaOaepAsymmetric, symmetric: newAes128Sha256RsaOaepSymmetric},
+	SecurityPolicyAes256Sha256RsaPss:          {asymmetric: newAes256Sha256RsaPssAsymmetric, symmetric: newAes256Sha256RsaPssSymmetric},
+	SecurityPolicyAes128Sha256NistP256:        {asymmetricKeyPair: newAes128Sha256NistP256Asymmetric, symmetric: newAes128Sha256NistP256Symmetric},
+	SecurityPolicyAes256Sha384NistP384:        {asymmetricKeyPair: newAes256Sha384NistP384Asymmetric, symmetric: newAes256Sha384NistP384Symmetric},
+	SecurityPolicyChaCha20Poly1305:            {asymmetricKeyPair: newChaCha20Poly1305Asymmetric, symmetric: newChaCha20Poly1305Symmetric},
+	// SecurityPolicyAes128Sha256BrainpoolP256r1 and SecurityPolicyAes256Sha384BrainpoolP384r1
+	// are deliberately not registered here: brainpoolP256r1/P384r1 are not
+	// implemented by crypto/ecdh, so their constructors cannot do real key
+	// agreement yet. Advertising them in SupportedPolicies() without a
+	// working implementation would be worse than not supporting them.
 }
 
 type policy struct {
-	asymmetric func(localKey *rsa.PrivateKey, remoteKey *rsa.PublicKey) (*EncryptionAlgorithm, error)
-	symmetric  func(localNonce []byte, remoteNonce []byte) (*EncryptionAlgorithm, error)
+	asymmetric        func(localKey *rsa.PrivateKey, remoteKey *rsa.PublicKey) (*EncryptionAlgorithm, error)
+	asymmetricKeyPair func(kp KeyPair, local *EphemeralKeyPair) (*EncryptionAlgorithm, error)
+	symmetric         func(localNonce []byte, remoteNonce []byte) (*EncryptionAlgorithm, error)
 }
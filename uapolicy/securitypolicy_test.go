@@ -0,0 +1,31 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package uapolicy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+// TestAsymmetricIdentityTypedNilRejected guards against a regression:
+// a typed-nil *rsa.PrivateKey wrapped in the SignerDecrypter interface
+// is a non-nil interface value, so local == nil alone does not catch
+// it. AsymmetricIdentity must still reject it with the "both keys
+// required" error instead of falling through to the generic
+// non-extractable-key path, which would panic dereferencing the nil
+// receiver.
+func TestAsymmetricIdentityTypedNilRejected(t *testing.T) {
+	remoteKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nilKey *rsa.PrivateKey
+	_, err = AsymmetricIdentity(SecurityPolicyBasic256Sha256, nilKey, &remoteKey.PublicKey)
+	if err == nil {
+		t.Fatal("expected an error for a typed-nil *rsa.PrivateKey, got nil")
+	}
+}